@@ -0,0 +1,123 @@
+// Package accesslog wraps an http.Handler so every request it serves is
+// recorded in Apache Combined Log Format, with an extra trailing field for
+// the caller's tailnet identity.
+package accesslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResponseRecorder wraps an http.ResponseWriter to capture the status code
+// and byte count written, for logging after the handler returns.
+type ResponseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// WriteHeader records status before delegating to the underlying writer.
+func (rw *ResponseRecorder) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the number of bytes written, defaulting status to 200 if
+// WriteHeader was never called explicitly.
+func (rw *ResponseRecorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += n
+	return n, err
+}
+
+// Status returns the response status code, defaulting to 200 if
+// WriteHeader was never called.
+func (rw *ResponseRecorder) Status() int {
+	if rw.status == 0 {
+		return http.StatusOK
+	}
+	return rw.status
+}
+
+// Bytes returns the number of response body bytes written so far.
+func (rw *ResponseRecorder) Bytes() int {
+	return rw.bytes
+}
+
+// Hijack delegates to the underlying ResponseWriter's http.Hijacker, so
+// wrapping a writer in a ResponseRecorder doesn't break CONNECT tunneling
+// or WebSocket upgrades, both of which require hijacking the connection.
+func (rw *ResponseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// Flush delegates to the underlying ResponseWriter's http.Flusher, if any.
+func (rw *ResponseRecorder) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// MarkHijacked records status for the access log entry of a request whose
+// connection was hijacked (e.g. a successful CONNECT), since the
+// ResponseWriter must not be written through once hijacked.
+func (rw *ResponseRecorder) MarkHijacked(status int) {
+	rw.status = status
+}
+
+// IdentityFunc resolves the tailnet identity of a peer from its remote
+// address (e.g. srv.WhoIs). It returns "" if the peer can't be identified.
+type IdentityFunc func(remoteAddr string) string
+
+// Middleware wraps next so every request is appended to out as one
+// Combined Log Format line, followed by the peer's tailnet identity.
+func Middleware(out io.Writer, identity IdentityFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &ResponseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		who := "-"
+		if identity != nil {
+			if id := identity(r.RemoteAddr); id != "" {
+				who = id
+			}
+		}
+
+		referer := r.Referer()
+		if referer == "" {
+			referer = "-"
+		}
+		agent := r.UserAgent()
+		if agent == "" {
+			agent = "-"
+		}
+
+		fmt.Fprintf(out, "%s - - [%s] %q %d %d %q %q %s\n",
+			remoteHost(r.RemoteAddr),
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto),
+			rec.Status(), rec.Bytes(),
+			referer, agent, who,
+		)
+	}
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,132 @@
+// Package auth provides pluggable authentication for the outgoing proxy
+// path. Schemes are selected at startup via a URL-style configuration
+// string (e.g. "static://?username=u&password=p") so new backends can be
+// added without touching main.go.
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Authenticator validates the credentials attached to a proxied request.
+type Authenticator interface {
+	// Authenticate reports whether r carries valid proxy credentials.
+	Authenticate(r *http.Request) bool
+}
+
+// New constructs an Authenticator from a URL-style spec, e.g.:
+//
+//	static://?username=u&password=p
+//	basicfile://?path=/etc/tsnet.htpasswd&reload=300
+//	none://
+//
+// An empty spec is equivalent to "none://".
+//
+// Any scheme may additionally carry a hidden_domain query parameter, e.g.
+// "static://?username=u&password=p&hidden_domain=/login". New returns its
+// value alongside the Authenticator for use with Gate.
+func New(spec string) (authn Authenticator, hiddenDomain string, err error) {
+	if spec == "" {
+		spec = "none://"
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid auth spec %q: %w", spec, err)
+	}
+	hiddenDomain = u.Query().Get("hidden_domain")
+
+	switch u.Scheme {
+	case "none":
+		authn = noneAuth{}
+	case "static":
+		authn, err = newStaticAuth(u.Query())
+	case "basicfile":
+		authn, err = newBasicFileAuth(u.Query())
+	default:
+		return nil, "", fmt.Errorf("unknown auth scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return authn, hiddenDomain, nil
+}
+
+// noneAuth authenticates every request; it backs "none://".
+type noneAuth struct{}
+
+func (noneAuth) Authenticate(*http.Request) bool { return true }
+
+// staticAuth authenticates against a single fixed username/password pair.
+type staticAuth struct {
+	username []byte
+	password []byte
+}
+
+func newStaticAuth(q url.Values) (Authenticator, error) {
+	username := q.Get("username")
+	password := q.Get("password")
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("static auth requires username and password")
+	}
+	return staticAuth{username: []byte(username), password: []byte(password)}, nil
+}
+
+func (a staticAuth) Authenticate(r *http.Request) bool {
+	username, password, ok := parseProxyBasicAuth(r)
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), a.username) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), a.password) == 1
+	return userOK && passOK
+}
+
+// parseProxyBasicAuth extracts the username/password from a
+// "Proxy-Authorization: Basic ..." header, mirroring the std library's
+// (*http.Request).BasicAuth but for the proxy header.
+func parseProxyBasicAuth(r *http.Request) (username, password string, ok bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	if auth == "" {
+		return "", "", false
+	}
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	cred := string(decoded)
+	idx := strings.IndexByte(cred, ':')
+	if idx < 0 {
+		return "", "", false
+	}
+	return cred[:idx], cred[idx+1:], true
+}
+
+// Gate wraps next so it only runs once a valid Authenticator has validated
+// the request's credentials. On failure it responds with 407 Proxy
+// Authentication Required, unless the request's path exactly matches
+// hiddenDomain, in which case it responds 401 so a browser re-prompts the
+// user for credentials from within the page itself.
+func Gate(authn Authenticator, hiddenDomain string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authn.Authenticate(r) {
+			next(w, r)
+			return
+		}
+		if hiddenDomain != "" && r.URL.Path == hiddenDomain {
+			w.Header().Set("WWW-Authenticate", `Basic realm="tsnet-gateway"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Proxy-Authenticate", `Basic realm="tsnet-gateway"`)
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func proxyBasicAuthRequest(username, password string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if username != "" || password != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		r.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	return r
+}
+
+func TestStaticAuthAuthenticate(t *testing.T) {
+	authn, err := newStaticAuth(map[string][]string{
+		"username": {"alice"},
+		"password": {"s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("newStaticAuth: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     bool
+	}{
+		{"correct credentials", "alice", "s3cret", true},
+		{"wrong password", "alice", "wrong", false},
+		{"wrong username", "bob", "s3cret", false},
+		{"no credentials", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := proxyBasicAuthRequest(tt.username, tt.password)
+			if got := authn.Authenticate(r); got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoneAuthAlwaysAuthenticates(t *testing.T) {
+	if !(noneAuth{}).Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Error("noneAuth.Authenticate() = false, want true")
+	}
+}
+
+func TestGateHiddenDomain(t *testing.T) {
+	authn, err := newStaticAuth(map[string][]string{
+		"username": {"alice"},
+		"password": {"s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("newStaticAuth: %v", err)
+	}
+	next := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	handler := Gate(authn, "/login", next)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{"hidden domain path gets 401", "/login", http.StatusUnauthorized},
+		{"other path gets 407", "/other", http.StatusProxyAuthRequired},
+		{"prefix of hidden domain does not match", "/login2", http.StatusProxyAuthRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGateAuthenticatedRequestPassesThrough(t *testing.T) {
+	authn, err := newStaticAuth(map[string][]string{
+		"username": {"alice"},
+		"password": {"s3cret"},
+	})
+	if err != nil {
+		t.Fatalf("newStaticAuth: %v", err)
+	}
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request) { called = true }
+	handler := Gate(authn, "/login", next)
+
+	r := proxyBasicAuthRequest("alice", "s3cret")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if !called {
+		t.Error("next was not called for an authenticated request")
+	}
+}
+
+func TestNewParsesHiddenDomain(t *testing.T) {
+	authn, hiddenDomain, err := New("static://?username=alice&password=s3cret&hidden_domain=/login")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if authn == nil {
+		t.Fatal("New returned a nil Authenticator")
+	}
+	if hiddenDomain != "/login" {
+		t.Errorf("hiddenDomain = %q, want %q", hiddenDomain, "/login")
+	}
+}
+
+func TestNewUnknownScheme(t *testing.T) {
+	if _, _, err := New("bogus://"); err == nil {
+		t.Error("New() with an unknown scheme should return an error")
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/tg123/go-htpasswd"
+)
+
+// basicFileAuth authenticates against an Apache htpasswd file, reloading it
+// from disk on a fixed interval so operators can rotate credentials without
+// restarting the gateway.
+type basicFileAuth struct {
+	path string
+	file atomic.Pointer[htpasswd.File]
+}
+
+func newBasicFileAuth(q url.Values) (Authenticator, error) {
+	path := q.Get("path")
+	if path == "" {
+		return nil, fmt.Errorf("basicfile auth requires path")
+	}
+	reload := 300
+	if v := q.Get("reload"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reload interval %q: %w", v, err)
+		}
+		reload = n
+	}
+
+	a := &basicFileAuth{path: path}
+	f, err := htpasswd.New(path, htpasswd.DefaultSystems, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load htpasswd file %q: %w", path, err)
+	}
+	a.file.Store(f)
+
+	if reload > 0 {
+		go a.reloadLoop(time.Duration(reload) * time.Second)
+	}
+	return a, nil
+}
+
+func (a *basicFileAuth) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		f, err := htpasswd.New(a.path, htpasswd.DefaultSystems, nil)
+		if err != nil {
+			log.Printf("auth: failed to reload htpasswd file %q: %v", a.path, err)
+			continue
+		}
+		a.file.Store(f)
+	}
+}
+
+func (a *basicFileAuth) Authenticate(r *http.Request) bool {
+	username, password, ok := parseProxyBasicAuth(r)
+	if !ok {
+		return false
+	}
+	return a.file.Load().Match(username, password)
+}
@@ -1,25 +1,51 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/natefinch/lumberjack.v2"
 	"tailscale.com/tsnet"
+
+	"github.com/daraccrafter/tsnet-gateway/accesslog"
+	"github.com/daraccrafter/tsnet-gateway/auth"
+	"github.com/daraccrafter/tsnet-gateway/metrics"
+	"github.com/daraccrafter/tsnet-gateway/routes"
+	"github.com/daraccrafter/tsnet-gateway/vhost"
 )
 
 var srv *tsnet.Server
-var routeConfig = make(map[string]string)
+var routeTable = routes.NewTable(nil)
+var vhostMux *vhost.Muxer
+var proxyAuth auth.Authenticator
+var proxyTransport *http.Transport
+var proxyTimeout time.Duration
+
+// insecureBackendTransport is shared by every https+insecure:// backend so
+// their TLS connections are pooled and reaped like any other Transport,
+// instead of a fresh one (and its connections) being discarded per request.
+var insecureBackendTransport = &http.Transport{
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+}
 
 func main() {
 	defaultBaseDir, err := os.Getwd()
@@ -35,29 +61,58 @@ func main() {
 	proxyType := flag.String("type", "gateway", "Specify mode: rproxy (reverse proxy), proxy (outgoing proxy), or gateway (both)")
 	rproxyPort := flag.Int("rproxy-port", 8443, "Port to listen on for reverse proxy")
 	hostname := flag.String("hostname", "tsnet-gateway", "Hostname to use for the Tailscale node")
+	authArg := flag.String("auth", "none://", "Proxy authentication scheme, with an optional hidden_domain query param (e.g. 'static://?username=u&password=p&hidden_domain=/login', 'basicfile://?path=/etc/tsnet.htpasswd&reload=300', 'none://')")
+	accessLogArg := flag.String("access-log", "", "Path to the Combined Log Format access log (defaults to access.log under the log directory)")
+	logMaxSizeMB := flag.Int("log-max-size-mb", 100, "Maximum size in megabytes before a log file is rotated")
+	logMaxBackups := flag.Int("log-max-backups", 7, "Maximum number of rotated log backups to retain")
+	logMaxAgeDays := flag.Int("log-max-age-days", 30, "Maximum age in days to retain rotated log backups")
+	proxyTimeoutArg := flag.Duration("proxy-timeout", 0, "Timeout for proxied requests and CONNECT tunnels (0 disables the timeout)")
+	metricsAddr := flag.String("metrics-addr", "", "Local address (e.g. 127.0.0.1:9100) to expose /metrics, /healthz, and /debug/pprof on; unset disables the admin listener")
 	flag.Parse()
 
+	proxyTimeout = *proxyTimeoutArg
+
 	logDir := filepath.Join(*baseDir, "tsnet-gateway", "logs")
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		log.Fatalf("Failed to create log directory: %v", err)
 	}
 
-	// Set up logging to both console and file
+	// Set up logging to both console and a rotated file
 	logFilePath := filepath.Join(logDir, "tsnet-gateway.log")
-	logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+	mainLog := &lumberjack.Logger{
+		Filename:   logFilePath,
+		MaxSize:    *logMaxSizeMB,
+		MaxBackups: *logMaxBackups,
+		MaxAge:     *logMaxAgeDays,
 	}
-	defer logFile.Close()
+	defer mainLog.Close()
 
-	log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	log.SetOutput(io.MultiWriter(os.Stdout, mainLog))
+
+	accessLogPath := *accessLogArg
+	if accessLogPath == "" {
+		accessLogPath = filepath.Join(logDir, "access.log")
+	}
+	accessLog := &lumberjack.Logger{
+		Filename:   accessLogPath,
+		MaxSize:    *logMaxSizeMB,
+		MaxBackups: *logMaxBackups,
+		MaxAge:     *logMaxAgeDays,
+	}
+	defer accessLog.Close()
 
 	if *authKey == "" {
 		log.Fatal("Error: --authkey is required")
 	}
 
+	a, hiddenDomain, err := auth.New(*authArg)
+	if err != nil {
+		log.Fatalf("Failed to configure auth: %v", err)
+	}
+	proxyAuth = a
+
 	if *proxyType == "rproxy" || *proxyType == "gateway" {
-		if err := loadRoutes(*routesArg, *routesFile); err != nil {
+		if err := loadRoutes(*routesArg, *routesFile, *rproxyPort); err != nil {
 			log.Fatalf("Failed to load routes: %v", err)
 		}
 	}
@@ -72,30 +127,125 @@ func main() {
 		log.Fatalf("Failed to start Tailscale: %v", err)
 	}
 
+	proxyTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return srv.Dial(ctx, network, addr)
+		},
+	}
+
+	vhostMux = vhost.NewMuxer(routeTable, dispatchRoute, strconv.Itoa(*rproxyPort))
+
+	if *metricsAddr != "" {
+		go startAdminServer(*metricsAddr)
+	}
+
 	if *proxyType == "proxy" || *proxyType == "gateway" {
-		go startProxy(*proxyPort)
+		go startProxy(*proxyPort, hiddenDomain, accessLog)
 	}
 	if *proxyType == "rproxy" || *proxyType == "gateway" {
-		go startTLSListener(*rproxyPort)
+		go startTLSListener(*rproxyPort, accessLog)
+	}
+
+	var watcher *fsnotify.Watcher
+	if *routesFile != "" && (*proxyType == "rproxy" || *proxyType == "gateway") {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Fatalf("Failed to create routes file watcher: %v", err)
+		}
+		defer w.Close()
+		if err := w.Add(filepath.Dir(*routesFile)); err != nil {
+			log.Fatalf("Failed to watch routes file: %v", err)
+		}
+		watcher = w
 	}
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt)
-	<-sigChan
-	log.Println("Shutting down...")
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGHUP)
+
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				log.Println("Received SIGHUP, reloading routes")
+				reloadRoutesFile(*routesFile)
+				continue
+			}
+			log.Println("Shutting down...")
+			return
+		case event := <-watcherEvents(watcher):
+			if event.Name == *routesFile && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				reloadRoutesFile(*routesFile)
+			}
+		case err := <-watcherErrors(watcher):
+			log.Printf("Routes file watcher error: %v", err)
+		}
+	}
 }
 
-func loadRoutes(routesArg, routesFile string) error {
+// watcherEvents returns w's event channel, or a nil channel (which blocks
+// forever in a select) if watching is disabled.
+func watcherEvents(w *fsnotify.Watcher) chan fsnotify.Event {
+	if w == nil {
+		return nil
+	}
+	return w.Events
+}
+
+// watcherErrors returns w's error channel, or a nil channel if watching is
+// disabled.
+func watcherErrors(w *fsnotify.Watcher) chan error {
+	if w == nil {
+		return nil
+	}
+	return w.Errors
+}
+
+// reloadRoutesFile re-reads routesFile and atomically swaps routeTable on
+// success. A parse error is logged and the previous table is kept in place
+// so a bad edit doesn't take the gateway down.
+func reloadRoutesFile(routesFile string) {
+	if routesFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(routesFile)
+	if err != nil {
+		log.Printf("Failed to reload routes file %q: %v", routesFile, err)
+		return
+	}
+	cfg, err := routes.ParseConfig(data)
+	if err != nil {
+		log.Printf("Failed to parse routes file %q, keeping previous routes: %v", routesFile, err)
+		return
+	}
+
+	added, removed := routes.Diff(routeTable.Load(), cfg)
+	routeTable.Store(cfg)
+	log.Printf("Reloaded routes from %q (added: %v, removed: %v)", routesFile, added, removed)
+}
+
+// loadRoutes populates routeTable from either the legacy comma-separated
+// --routes flag (expanded into a single wildcard "*:<rproxyPort>" host entry)
+// or a structured --routes-file JSON document.
+func loadRoutes(routesArg, routesFile string, rproxyPort int) error {
 	if routesArg != "" {
+		handlers := make(map[string]*routes.Handler)
 		pairs := strings.Split(routesArg, ",")
 		for _, pair := range pairs {
 			parts := strings.SplitN(pair, "=", 2)
 			if len(parts) != 2 {
 				return fmt.Errorf("invalid route format: %s", pair)
 			}
-			routeConfig[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			prefix := strings.TrimSpace(parts[0])
+			handlers[prefix] = &routes.Handler{Proxy: strings.TrimSpace(parts[1])}
+		}
+		cfg := routes.Config{
+			fmt.Sprintf("*:%d", rproxyPort): {Handlers: handlers},
+		}
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("invalid routes: %w", err)
 		}
-		log.Printf("Loaded routes from command-line argument: %+v", routeConfig)
+		routeTable.Store(cfg)
+		log.Printf("Loaded routes from command-line argument: %s", routesArg)
 		return nil
 	}
 
@@ -105,20 +255,50 @@ func loadRoutes(routesArg, routesFile string) error {
 			return fmt.Errorf("failed to read routes file: %w", err)
 		}
 
-		err = json.Unmarshal(data, &routeConfig)
+		cfg, err := routes.ParseConfig(data)
 		if err != nil {
 			return fmt.Errorf("failed to parse routes file: %w", err)
 		}
 
-		log.Printf("Loaded routes from file: %+v", routeConfig)
+		routeTable.Store(cfg)
+		log.Printf("Loaded routes from file: %s", routesFile)
 		return nil
 	}
 
 	return nil
 }
 
-func startProxy(proxyPort int) {
-	proxyHandler := http.HandlerFunc(handleProxyRequest)
+// startAdminServer runs the admin HTTP server exposing Prometheus metrics,
+// a health check, and pprof profiles. It must only ever listen on a local
+// address: unlike the rproxy listener, it is never reachable over the
+// tailnet.
+func startAdminServer(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	log.Printf("Admin server (metrics, healthz, pprof) started on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("Admin server failed: %v", err)
+	}
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := srv.Up(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func startProxy(proxyPort int, hiddenDomain string, accessLog io.Writer) {
+	proxyHandler := auth.Gate(proxyAuth, hiddenDomain, handleProxyRequest)
+	proxyHandler = accesslog.Middleware(accessLog, whoIsIdentity, proxyHandler)
 	msg := fmt.Sprintf("Proxy server started on http://localhost:%d", proxyPort)
 	log.Println(msg)
 	err := http.ListenAndServe(fmt.Sprintf("localhost:%d", proxyPort), proxyHandler)
@@ -127,52 +307,109 @@ func startProxy(proxyPort int) {
 	}
 }
 
-func startTLSListener(rproxyPort int) {
+func startTLSListener(rproxyPort int, accessLog io.Writer) {
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		log.Fatalf("Failed to build per-host TLS config: %v", err)
+	}
 
-	ln, err := srv.ListenTLS("tcp", fmt.Sprintf(":%d", rproxyPort))
+	ln, err := srv.Listen("tcp", fmt.Sprintf(":%d", rproxyPort))
 	if err != nil {
 		log.Fatalf("Failed to start TLS listener: %v", err)
 	}
 	defer ln.Close()
 
+	tlsLn := tls.NewListener(ln, tlsConfig)
+
 	log.Println("TLS server started on port 443 (inside Tailnet)")
-	http.Serve(ln, http.HandlerFunc(routeRequest))
+	http.Serve(tlsLn, accesslog.Middleware(accessLog, whoIsIdentity, vhostMux.ServeHTTP))
 }
 
-func routeRequest(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request: %s %s", r.Method, r.URL.Path)
+// newTLSConfig builds a tls.Config that fetches a certificate per incoming
+// SNI from the tailnet's cert store, so one listener can terminate TLS for
+// every MagicDNS name the vhost muxer routes.
+func newTLSConfig() (*tls.Config, error) {
+	lc, err := srv.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, key, err := lc.CertPair(hello.Context(), hello.ServerName)
+			if err != nil {
+				return nil, err
+			}
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+			return &pair, nil
+		},
+	}, nil
+}
 
-	for prefix, backendURL := range routeConfig {
-		if strings.HasPrefix(r.URL.Path, prefix) {
-			log.Printf("Forwarding request %s to backend %s", r.URL.Path, backendURL)
-			forwardRequest(backendURL, prefix, w, r)
-			return
-		}
+// whoIsIdentity resolves the tailnet login name of the peer at remoteAddr,
+// for attribution in the access log.
+func whoIsIdentity(remoteAddr string) string {
+	who, err := srv.WhoIs(remoteAddr)
+	if err != nil || who == nil || who.UserProfile == nil {
+		return ""
 	}
+	return who.UserProfile.LoginName
+}
+
+// dispatchRoute is the vhost.Dispatcher passed to vhostMux: it records
+// per-prefix metrics around serving the resolved handler.
+func dispatchRoute(h *routes.Handler, prefix string, w http.ResponseWriter, r *http.Request) {
+	rec := &accesslog.ResponseRecorder{ResponseWriter: w}
+	defer func() {
+		metrics.RequestsTotal.WithLabelValues(prefix, strconv.Itoa(rec.Status())).Inc()
+		metrics.BytesTransferred.WithLabelValues(prefix).Observe(float64(rec.Bytes()))
+	}()
 
-	http.Error(w, "No matching route", http.StatusNotFound)
+	serveHandler(h, prefix, rec, r)
 }
 
-func forwardRequest(backendURL, prefix string, w http.ResponseWriter, r *http.Request) {
-	target, err := url.Parse(backendURL)
-	if err != nil {
-		http.Error(w, "Invalid backend URL", http.StatusInternalServerError)
-		return
+func serveHandler(h *routes.Handler, prefix string, w http.ResponseWriter, r *http.Request) {
+	switch {
+	case h.Proxy != "":
+		forwardRequest(h.Backend(), h.RewriteHost, prefix, w, r)
+	case h.Text != "":
+		io.WriteString(w, h.Text)
+	case h.Path != "":
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		if !strings.HasPrefix(r.URL.Path, "/") {
+			r.URL.Path = "/" + r.URL.Path
+		}
+		http.ServeFile(w, r, filepath.Join(h.Path, r.URL.Path))
 	}
+}
+
+func forwardRequest(backend *routes.Backend, rewriteHost, prefix string, w http.ResponseWriter, r *http.Request) {
+	target := backend.URL
 
 	r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
 	if !strings.HasPrefix(r.URL.Path, "/") {
 		r.URL.Path = "/" + r.URL.Path
 	}
 
-	log.Printf("Stripped path: Forwarding to %s%s", backendURL, r.URL.Path)
+	log.Printf("Stripped path: Forwarding to %s%s", target, r.URL.Path)
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
+	if backend.Insecure {
+		proxy.Transport = insecureBackendTransport
+	}
 	r.URL.Host = target.Host
 	r.URL.Scheme = target.Scheme
-	r.Host = target.Host
+	if rewriteHost != "" {
+		r.Host = rewriteHost
+	} else {
+		r.Host = target.Host
+	}
 
+	start := time.Now()
 	proxy.ServeHTTP(w, r)
+	metrics.UpstreamLatencySeconds.WithLabelValues(prefix).Observe(time.Since(start).Seconds())
 }
 
 func handleProxyRequest(w http.ResponseWriter, r *http.Request) {
@@ -186,19 +423,51 @@ func handleProxyRequest(w http.ResponseWriter, r *http.Request) {
 	handleHTTPProxy(w, r)
 }
 
+// hopHeaders are connection-scoped headers that must not be forwarded by a
+// proxy, per RFC 7230 6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Te",
+	"Trailers",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Proxy-Authorization",
+}
+
+func stripHopHeaders(h http.Header) {
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
+
 func handleHTTPSProxy(w http.ResponseWriter, r *http.Request) {
-	destConn, err := srv.Dial(r.Context(), "tcp", r.Host)
+	ctx := r.Context()
+	if proxyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, proxyTimeout)
+		defer cancel()
+	}
+
+	destConn, err := srv.Dial(ctx, "tcp", r.Host)
 	if err != nil {
 		log.Printf("Failed to connect to target: %v", err)
 		http.Error(w, "Failed to connect to target", http.StatusBadGateway)
 		return
 	}
-	defer destConn.Close()
-
-	w.WriteHeader(http.StatusOK)
 
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
+		destConn.Close()
 		log.Println("Hijacking not supported")
 		http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
 		return
@@ -206,30 +475,116 @@ func handleHTTPSProxy(w http.ResponseWriter, r *http.Request) {
 
 	clientConn, _, err := hijacker.Hijack()
 	if err != nil {
+		destConn.Close()
 		log.Printf("Failed to hijack connection: %v", err)
 		http.Error(w, "Failed to hijack connection", http.StatusInternalServerError)
 		return
 	}
-	defer clientConn.Close()
 
-	go io.Copy(destConn, clientConn)
-	io.Copy(clientConn, destConn)
+	// The 200 response must be written directly onto the now-hijacked
+	// connection: once hijacked, writing through the ResponseWriter is
+	// undefined.
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		log.Printf("Failed to write CONNECT response: %v", err)
+		clientConn.Close()
+		destConn.Close()
+		return
+	}
+	if rec, ok := w.(*accesslog.ResponseRecorder); ok {
+		rec.MarkHijacked(http.StatusOK)
+	}
+
+	metrics.ActiveConnectTunnels.Inc()
+	defer metrics.ActiveConnectTunnels.Dec()
+
+	if proxyTimeout > 0 {
+		// ctx's timer is already armed from the Dial above; watch it for the
+		// lifetime of the tunnel too, so --proxy-timeout bounds the whole
+		// CONNECT session and not just the initial dial.
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				clientConn.Close()
+				destConn.Close()
+			case <-done:
+			}
+		}()
+	}
+
+	tunnel(clientConn, destConn)
 }
 
-func handleHTTPProxy(w http.ResponseWriter, r *http.Request) {
-	destConn, err := srv.Dial(r.Context(), "tcp", r.Host)
-	if err != nil {
-		log.Printf("Failed to connect to target: %v", err)
-		http.Error(w, "Failed to connect to target", http.StatusBadGateway)
+// tunnel relays bytes bidirectionally between client and dest. Each
+// direction is half-closed as soon as its reader hits EOF, so a
+// unidirectional shutdown (e.g. TLS close_notify) doesn't hang the other
+// direction indefinitely. A conn without CloseWrite is closed fully
+// instead.
+func tunnel(client, dest net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		io.Copy(dest, client)
+		closeWrite(dest)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(client, dest)
+		closeWrite(client)
+	}()
+
+	wg.Wait()
+	client.Close()
+	dest.Close()
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(closeWriter); ok {
+		cw.CloseWrite()
 		return
 	}
-	defer destConn.Close()
+	conn.Close()
+}
 
-	if err := r.Write(destConn); err != nil {
+func handleHTTPProxy(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if proxyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, proxyTimeout)
+		defer cancel()
+	}
+
+	outReq := r.Clone(ctx)
+	outReq.RequestURI = ""
+	if !outReq.URL.IsAbs() {
+		outReq.URL.Scheme = "http"
+		outReq.URL.Host = outReq.Host
+	}
+	stripHopHeaders(outReq.Header)
+
+	start := time.Now()
+	resp, err := proxyTransport.RoundTrip(outReq)
+	metrics.UpstreamLatencySeconds.WithLabelValues("proxy").Observe(time.Since(start).Seconds())
+	if err != nil {
 		log.Printf("Failed to forward request: %v", err)
-		http.Error(w, "Failed to forward request", http.StatusInternalServerError)
+		http.Error(w, "Failed to forward request", http.StatusBadGateway)
+		metrics.RequestsTotal.WithLabelValues("proxy", strconv.Itoa(http.StatusBadGateway)).Inc()
 		return
 	}
+	defer resp.Body.Close()
+
+	stripHopHeaders(resp.Header)
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	n, _ := io.Copy(w, resp.Body)
 
-	io.Copy(w, destConn)
+	metrics.RequestsTotal.WithLabelValues("proxy", strconv.Itoa(resp.StatusCode)).Inc()
+	metrics.BytesTransferred.WithLabelValues("proxy").Observe(float64(n))
 }
@@ -0,0 +1,42 @@
+// Package metrics holds the Prometheus collectors exported by the gateway's
+// admin listener, kept separate from the handler code that updates them.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// RequestsTotal counts requests handled by route and final status code.
+	RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "tsnet_gateway",
+		Name:      "requests_total",
+		Help:      "Total requests handled, by route and status.",
+	}, []string{"route", "status"})
+
+	// UpstreamLatencySeconds observes how long upstream round trips take,
+	// by route.
+	UpstreamLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tsnet_gateway",
+		Name:      "upstream_latency_seconds",
+		Help:      "Latency of upstream requests, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// BytesTransferred observes response body size, by route.
+	BytesTransferred = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "tsnet_gateway",
+		Name:      "bytes_transferred",
+		Help:      "Bytes transferred in responses, by route.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"route"})
+
+	// ActiveConnectTunnels gauges how many CONNECT tunnels are currently
+	// open.
+	ActiveConnectTunnels = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "tsnet_gateway",
+		Name:      "active_connect_tunnels",
+		Help:      "Number of currently open CONNECT tunnels.",
+	})
+)
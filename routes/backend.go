@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Handler is a single path-prefix route target: exactly one of Proxy, Text,
+// or Path should be set.
+type Handler struct {
+	Proxy string `json:"proxy,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Path  string `json:"path,omitempty"`
+
+	// RewriteHost, if set, overrides the upstream Host header independently
+	// of the incoming request's Host, e.g. when several MagicDNS names
+	// front the same backend that expects one canonical Host.
+	RewriteHost string `json:"rewrite_host,omitempty"`
+
+	backend *Backend
+}
+
+// Backend is a parsed reverse-proxy target.
+type Backend struct {
+	URL      *url.URL
+	Insecure bool // true for https+insecure://: dial TLS, skip cert verification
+}
+
+var barePortRE = regexp.MustCompile(`^\d+$`)
+
+// resolve parses Proxy (if set) into a Backend, mirroring Tailscale's
+// expandProxyArg: a bare port expands to http://127.0.0.1:<port>, a
+// "host:port" pair defaults to http, and full URLs are used as-is except
+// for the synthetic https+insecure:// scheme.
+func (h *Handler) resolve() error {
+	set := 0
+	for _, v := range []string{h.Proxy, h.Text, h.Path} {
+		if v != "" {
+			set++
+		}
+	}
+	switch set {
+	case 0:
+		return fmt.Errorf("handler has no proxy, text, or path backend")
+	case 1:
+		// ok
+	default:
+		return fmt.Errorf("handler must set exactly one of proxy, text, path")
+	}
+	if h.Proxy == "" {
+		return nil
+	}
+	backend, err := ParseBackend(h.Proxy)
+	if err != nil {
+		return err
+	}
+	h.backend = backend
+	return nil
+}
+
+// Backend returns the parsed proxy backend for h, if any.
+func (h *Handler) Backend() *Backend {
+	return h.backend
+}
+
+// ParseBackend parses a proxy backend spec into a Backend.
+func ParseBackend(spec string) (*Backend, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("empty backend spec")
+	}
+	if barePortRE.MatchString(spec) {
+		return &Backend{URL: &url.URL{Scheme: "http", Host: "127.0.0.1:" + spec}}, nil
+	}
+	if !strings.Contains(spec, "://") {
+		return &Backend{URL: &url.URL{Scheme: "http", Host: spec}}, nil
+	}
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backend url %q: %w", spec, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return &Backend{URL: u}, nil
+	case "https+insecure":
+		insecure := *u
+		insecure.Scheme = "https"
+		return &Backend{URL: &insecure, Insecure: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend scheme %q", u.Scheme)
+	}
+}
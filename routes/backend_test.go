@@ -0,0 +1,59 @@
+package routes
+
+import "testing"
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         string
+		wantScheme   string
+		wantHost     string
+		wantInsecure bool
+		wantErr      bool
+	}{
+		{"bare port", "3030", "http", "127.0.0.1:3030", false, false},
+		{"host:port defaults to http", "example.com:8080", "http", "example.com:8080", false, false},
+		{"http url", "http://example.com", "http", "example.com", false, false},
+		{"https url", "https://example.com", "https", "example.com", false, false},
+		{"https+insecure rewrites scheme and sets Insecure", "https+insecure://example.com", "https", "example.com", true, false},
+		{"empty spec", "", "", "", false, true},
+		{"unknown scheme", "ftp://example.com", "", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := ParseBackend(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("ParseBackend() error = nil, want non-nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBackend() error = %v", err)
+			}
+			if backend.URL.Scheme != tt.wantScheme {
+				t.Errorf("Scheme = %q, want %q", backend.URL.Scheme, tt.wantScheme)
+			}
+			if backend.URL.Host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", backend.URL.Host, tt.wantHost)
+			}
+			if backend.Insecure != tt.wantInsecure {
+				t.Errorf("Insecure = %v, want %v", backend.Insecure, tt.wantInsecure)
+			}
+		})
+	}
+}
+
+func TestHandlerResolveRejectsMultipleBackends(t *testing.T) {
+	h := &Handler{Proxy: "3030", Text: "hello"}
+	if err := h.resolve(); err == nil {
+		t.Error("resolve() error = nil, want non-nil for a handler with both proxy and text set")
+	}
+}
+
+func TestHandlerResolveRejectsNoBackend(t *testing.T) {
+	h := &Handler{}
+	if err := h.resolve(); err == nil {
+		t.Error("resolve() error = nil, want non-nil for a handler with no backend")
+	}
+}
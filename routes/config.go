@@ -0,0 +1,87 @@
+// Package routes implements a Tailscale ipn.ServeConfig-style routing table:
+// a JSON document keyed by "host:port" whose values map path prefixes to a
+// backend (a reverse-proxied URL, an inline string, or a static directory).
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Config is a routing table keyed by "host:port" (or "*:port" as a
+// host-agnostic wildcard).
+type Config map[string]*HostConfig
+
+// HostConfig holds the path-prefix routes served for one host:port.
+type HostConfig struct {
+	Handlers map[string]*Handler `json:"Handlers"`
+}
+
+// ParseConfig parses and validates a routes JSON document.
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse routes config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate resolves every handler's backend and rejects unknown schemes or
+// prefixes that overlap once trailing slashes are normalized. It must be
+// called on any Config assembled outside of ParseConfig.
+func (c Config) Validate() error {
+	for hostPort, hc := range c {
+		seen := make(map[string]string, len(hc.Handlers))
+		for prefix, h := range hc.Handlers {
+			if err := h.resolve(); err != nil {
+				return fmt.Errorf("%s%s: %w", hostPort, prefix, err)
+			}
+			norm := strings.TrimSuffix(prefix, "/")
+			if other, ok := seen[norm]; ok {
+				return fmt.Errorf("%s: prefixes %q and %q overlap", hostPort, other, prefix)
+			}
+			seen[norm] = prefix
+		}
+	}
+	return nil
+}
+
+// Match returns the HostConfig for hostHeader, trying an exact "host:port"
+// match first and falling back to a "*:port" wildcard entry. hostHeader
+// rarely carries an explicit port for the default port of its scheme (e.g.
+// an HTTPS request's Host is just "example.com", not "example.com:443"),
+// so defaultPort is substituted for both lookups when hostHeader has none.
+func (c Config) Match(hostHeader, defaultPort string) (*HostConfig, bool) {
+	if hc, ok := c[hostHeader]; ok {
+		return hc, true
+	}
+	host, port, err := net.SplitHostPort(hostHeader)
+	if err != nil {
+		host, port = hostHeader, defaultPort
+	}
+	if port == "" {
+		return nil, false
+	}
+	if hc, ok := c[host+":"+port]; ok {
+		return hc, true
+	}
+	hc, ok := c["*:"+port]
+	return hc, ok
+}
+
+// MatchHandler returns the handler whose prefix is the longest match for
+// path, along with that prefix.
+func (hc *HostConfig) MatchHandler(path string) (h *Handler, prefix string, ok bool) {
+	for p, candidate := range hc.Handlers {
+		if strings.HasPrefix(path, p) && len(p) > len(prefix) {
+			prefix = p
+			h = candidate
+		}
+	}
+	return h, prefix, h != nil
+}
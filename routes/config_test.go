@@ -0,0 +1,94 @@
+package routes
+
+import "testing"
+
+func TestConfigMatch(t *testing.T) {
+	cfg := Config{
+		"example.com:443": &HostConfig{},
+		"*:443":           &HostConfig{},
+		"*:8443":          &HostConfig{},
+	}
+	exact := cfg["example.com:443"]
+	defaultWildcard := cfg["*:443"]
+	wildcard := cfg["*:8443"]
+
+	tests := []struct {
+		name       string
+		host       string
+		wantConfig *HostConfig
+		wantOK     bool
+	}{
+		{"exact host:port match", "example.com:443", exact, true},
+		{"portless host falls back to host:defaultPort", "example.com", exact, true},
+		{"portless unknown host falls back to wildcard", "other.com", defaultWildcard, true},
+		{"explicit port matches wildcard", "other.com:8443", wildcard, true},
+		{"no match", "other.com:9999", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cfg.Match(tt.host, "443")
+			if ok != tt.wantOK {
+				t.Fatalf("Match() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && got != tt.wantConfig {
+				t.Errorf("Match() = %v, want %v", got, tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestHostConfigMatchHandlerLongestPrefix(t *testing.T) {
+	root := &Handler{Text: "root"}
+	api := &Handler{Text: "api"}
+	apiV2 := &Handler{Text: "api v2"}
+	hc := &HostConfig{Handlers: map[string]*Handler{
+		"/":       root,
+		"/api":    api,
+		"/api/v2": apiV2,
+	}}
+
+	tests := []struct {
+		name        string
+		path        string
+		wantHandler *Handler
+		wantPrefix  string
+	}{
+		{"root", "/other", root, "/"},
+		{"api prefix", "/api/v1/things", api, "/api"},
+		{"longest prefix wins", "/api/v2/things", apiV2, "/api/v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, prefix, ok := hc.MatchHandler(tt.path)
+			if !ok {
+				t.Fatal("MatchHandler() ok = false, want true")
+			}
+			if h != tt.wantHandler || prefix != tt.wantPrefix {
+				t.Errorf("MatchHandler() = (%v, %q), want (%v, %q)", h, prefix, tt.wantHandler, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := Config{
+		"example.com:443": &HostConfig{Handlers: map[string]*Handler{
+			"/":    {Text: "root"},
+			"/old": {Text: "old"},
+		}},
+	}
+	updated := Config{
+		"example.com:443": &HostConfig{Handlers: map[string]*Handler{
+			"/":    {Text: "root"},
+			"/new": {Text: "new"},
+		}},
+	}
+
+	added, removed := Diff(old, updated)
+	if len(added) != 1 || added[0] != "example.com:443/new" {
+		t.Errorf("added = %v, want [example.com:443/new]", added)
+	}
+	if len(removed) != 1 || removed[0] != "example.com:443/old" {
+		t.Errorf("removed = %v, want [example.com:443/old]", removed)
+	}
+}
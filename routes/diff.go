@@ -0,0 +1,34 @@
+package routes
+
+import "sort"
+
+// Diff reports the "host:port<prefix>" route keys added and removed between
+// old and new, for logging on reload.
+func Diff(old, new Config) (added, removed []string) {
+	oldKeys := routeKeys(old)
+	newKeys := routeKeys(new)
+
+	for k := range newKeys {
+		if !oldKeys[k] {
+			added = append(added, k)
+		}
+	}
+	for k := range oldKeys {
+		if !newKeys[k] {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func routeKeys(c Config) map[string]bool {
+	keys := make(map[string]bool)
+	for hostPort, hc := range c {
+		for prefix := range hc.Handlers {
+			keys[hostPort+prefix] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,30 @@
+package routes
+
+import "sync/atomic"
+
+// Table holds a Config behind an atomic pointer so readers always see a
+// consistent snapshot while a reload swaps in a new one.
+type Table struct {
+	ptr atomic.Pointer[Config]
+}
+
+// NewTable creates a Table holding the given initial Config.
+func NewTable(cfg Config) *Table {
+	t := &Table{}
+	t.Store(cfg)
+	return t
+}
+
+// Load returns the Config snapshot currently in effect.
+func (t *Table) Load() Config {
+	p := t.ptr.Load()
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// Store atomically replaces the Config snapshot.
+func (t *Table) Store(cfg Config) {
+	t.ptr.Store(&cfg)
+}
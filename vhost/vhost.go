@@ -0,0 +1,54 @@
+// Package vhost dispatches incoming requests to per-host route tables,
+// mirroring the hostname+location dispatch model frp's vhost muxer uses,
+// but scoped to tsnet's TLS listener so a single gateway node can front
+// several MagicDNS names.
+package vhost
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/daraccrafter/tsnet-gateway/routes"
+)
+
+// Dispatcher serves a single request once Muxer has resolved it to a
+// handler and the path prefix that matched.
+type Dispatcher func(h *routes.Handler, prefix string, w http.ResponseWriter, r *http.Request)
+
+// Muxer looks up r.Host (and, via the TLS layer's SNI-driven certificate
+// callback, the same host) against a routes.Table before handing off to a
+// Dispatcher. Route lookups go through the Table's atomic snapshot, so
+// registering or unregistering hosts via a config reload is safe under
+// concurrent requests.
+type Muxer struct {
+	table       *routes.Table
+	dispatch    Dispatcher
+	defaultPort string
+}
+
+// NewMuxer creates a Muxer serving routes from table via dispatch. defaultPort
+// is used to resolve the "*:port" wildcard when an incoming request's Host
+// header carries no explicit port, as is typical for the default port of
+// whatever scheme the client used (e.g. 443 for HTTPS).
+func NewMuxer(table *routes.Table, dispatch Dispatcher, defaultPort string) *Muxer {
+	return &Muxer{table: table, dispatch: dispatch, defaultPort: defaultPort}
+}
+
+func (m *Muxer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hc, ok := m.table.Load().Match(r.Host, m.defaultPort)
+	if !ok {
+		log.Printf("[%s] no matching host route for %s %s", r.Host, r.Method, r.URL.Path)
+		http.Error(w, "No matching route", http.StatusNotFound)
+		return
+	}
+
+	h, prefix, ok := hc.MatchHandler(r.URL.Path)
+	if !ok {
+		log.Printf("[%s] no matching prefix route for %s %s", r.Host, r.Method, r.URL.Path)
+		http.Error(w, "No matching route", http.StatusNotFound)
+		return
+	}
+
+	log.Printf("[%s] %s %s -> prefix %q", r.Host, r.Method, r.URL.Path, prefix)
+	m.dispatch(h, prefix, w, r)
+}